@@ -0,0 +1,268 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeaffinity
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// nodeIndexSet is a set of indices into Index.nodes.
+type nodeIndexSet map[int]struct{}
+
+// Index is an inverted-label/-field index over a fixed set of nodes, letting
+// NodeSelector/PreferredSchedulingTerms be evaluated in roughly O(matches)
+// rather than the O(nodes x terms x requirements) implied by calling Match
+// once per node. It is meant for controllers - provisioners, autoscalers -
+// that repeatedly evaluate selectors against the same, large node set.
+type Index struct {
+	nodes      []*v1.Node
+	nodeLabels []labels.Set
+	nodeFields []fields.Set
+	labelIndex map[string]map[string]nodeIndexSet
+	fieldIndex map[string]map[string]nodeIndexSet
+}
+
+// NewIndex builds an Index over nodes. The index is a point-in-time
+// snapshot: it does not observe later changes to nodes.
+func NewIndex(nodes []*v1.Node) *Index {
+	idx := &Index{
+		nodes:      nodes,
+		nodeLabels: make([]labels.Set, len(nodes)),
+		nodeFields: make([]fields.Set, len(nodes)),
+		labelIndex: map[string]map[string]nodeIndexSet{},
+		fieldIndex: map[string]map[string]nodeIndexSet{},
+	}
+	for i, node := range nodes {
+		idx.nodeLabels[i] = labels.Set(node.Labels)
+		idx.nodeFields[i] = extractNodeFields(node)
+		for key, value := range node.Labels {
+			insertIndex(idx.labelIndex, key, value, i)
+		}
+		for key, value := range idx.nodeFields[i] {
+			insertIndex(idx.fieldIndex, key, value, i)
+		}
+	}
+	return idx
+}
+
+func insertIndex(index map[string]map[string]nodeIndexSet, key, value string, i int) {
+	values, ok := index[key]
+	if !ok {
+		values = map[string]nodeIndexSet{}
+		index[key] = values
+	}
+	set, ok := values[value]
+	if !ok {
+		set = nodeIndexSet{}
+		values[value] = set
+	}
+	set[i] = struct{}{}
+}
+
+// NodeScore pairs a node from the Index with the score PreferredSchedulingTerms
+// gave it.
+type NodeScore struct {
+	Node  *v1.Node
+	Score int64
+}
+
+// Matching returns every node matching sel.
+func (idx *Index) Matching(sel *NodeSelector) []*v1.Node {
+	matched := idx.unionTerms(sel.terms)
+	out := make([]*v1.Node, 0, len(matched))
+	for i, node := range idx.nodes {
+		if _, ok := matched[i]; ok {
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+// Scoring returns every node in the index together with the sum of the
+// weights of the preferred terms it matches.
+func (idx *Index) Scoring(terms *PreferredSchedulingTerms) []NodeScore {
+	scores := make([]int64, len(idx.nodes))
+	for _, term := range terms.terms {
+		matched, ok := idx.evaluateTerm(term.nodeSelectorTerm)
+		if !ok {
+			continue
+		}
+		for i := range matched {
+			scores[i] += int64(term.weight)
+		}
+	}
+	out := make([]NodeScore, len(idx.nodes))
+	for i, node := range idx.nodes {
+		out[i] = NodeScore{Node: node, Score: scores[i]}
+	}
+	return out
+}
+
+func (idx *Index) unionTerms(terms []nodeSelectorTerm) nodeIndexSet {
+	matched := nodeIndexSet{}
+	for _, term := range terms {
+		termMatches, ok := idx.evaluateTerm(term)
+		if !ok {
+			continue
+		}
+		for i := range termMatches {
+			matched[i] = struct{}{}
+		}
+	}
+	return matched
+}
+
+// evaluateTerm intersects the index-backed candidate sets for every In,
+// NotIn, Exists and DoesNotExist requirement in term, falling back to a
+// linear scan over the narrowed candidate set for Gt/Lt. It returns
+// ok=false for a term with a deferred parsing error (see
+// LazyErrorNodeSelector), which never matches.
+func (idx *Index) evaluateTerm(term nodeSelectorTerm) (nodeIndexSet, bool) {
+	if term.parsingErr != nil {
+		return nil, false
+	}
+	var candidate nodeIndexSet // nil means "unconstrained so far"
+	// Gt/Lt requirements, scanned once the index lookups have narrowed candidate.
+	var ranged []labels.Requirement
+
+	if term.matchLabels != nil {
+		reqs, selectable := term.matchLabels.Requirements()
+		if !selectable {
+			return nil, false
+		}
+		for _, req := range reqs {
+			if req.Operator() == selection.GreaterThan || req.Operator() == selection.LessThan {
+				ranged = append(ranged, req)
+				continue
+			}
+			candidate = intersectOrSet(candidate, idx.labelCandidates(req))
+		}
+	}
+	if term.matchFields != nil {
+		for _, req := range term.matchFields.Requirements() {
+			candidate = intersectOrSet(candidate, idx.fieldCandidates(req))
+		}
+	}
+	for _, req := range ranged {
+		candidate = idx.scanLabel(req, candidate)
+	}
+	if candidate == nil {
+		candidate = idx.allIndices()
+	}
+	return candidate, true
+}
+
+func (idx *Index) labelCandidates(req labels.Requirement) nodeIndexSet {
+	switch req.Operator() {
+	case selection.In:
+		return idx.unionValues(idx.labelIndex[req.Key()], req.Values().List())
+	case selection.NotIn:
+		return idx.complement(idx.unionValues(idx.labelIndex[req.Key()], req.Values().List()))
+	case selection.Exists:
+		return idx.keyPresence(idx.labelIndex[req.Key()])
+	case selection.DoesNotExist:
+		return idx.complement(idx.keyPresence(idx.labelIndex[req.Key()]))
+	default:
+		return nodeIndexSet{}
+	}
+}
+
+func (idx *Index) fieldCandidates(req fields.Requirement) nodeIndexSet {
+	if req.Operator == selection.NotEquals {
+		return idx.complement(idx.fieldIndex[req.Field][req.Value])
+	}
+	return cloneSet(idx.fieldIndex[req.Field][req.Value])
+}
+
+// scanLabel linearly scans candidate (or every node, if candidate is still
+// unconstrained) for the Gt/Lt requirement req, which the label index can't
+// answer directly.
+func (idx *Index) scanLabel(req labels.Requirement, candidate nodeIndexSet) nodeIndexSet {
+	base := candidate
+	if base == nil {
+		base = idx.allIndices()
+	}
+	out := nodeIndexSet{}
+	for i := range base {
+		if req.Matches(idx.nodeLabels[i]) {
+			out[i] = struct{}{}
+		}
+	}
+	return out
+}
+
+func (idx *Index) unionValues(values map[string]nodeIndexSet, keys []string) nodeIndexSet {
+	out := nodeIndexSet{}
+	for _, k := range keys {
+		for i := range values[k] {
+			out[i] = struct{}{}
+		}
+	}
+	return out
+}
+
+func (idx *Index) keyPresence(values map[string]nodeIndexSet) nodeIndexSet {
+	out := nodeIndexSet{}
+	for _, set := range values {
+		for i := range set {
+			out[i] = struct{}{}
+		}
+	}
+	return out
+}
+
+func (idx *Index) complement(set nodeIndexSet) nodeIndexSet {
+	out := nodeIndexSet{}
+	for i := range idx.nodes {
+		if _, ok := set[i]; !ok {
+			out[i] = struct{}{}
+		}
+	}
+	return out
+}
+
+func (idx *Index) allIndices() nodeIndexSet {
+	out := make(nodeIndexSet, len(idx.nodes))
+	for i := range idx.nodes {
+		out[i] = struct{}{}
+	}
+	return out
+}
+
+func intersectOrSet(candidate, set nodeIndexSet) nodeIndexSet {
+	if candidate == nil {
+		return set
+	}
+	out := nodeIndexSet{}
+	for i := range candidate {
+		if _, ok := set[i]; ok {
+			out[i] = struct{}{}
+		}
+	}
+	return out
+}
+
+func cloneSet(set nodeIndexSet) nodeIndexSet {
+	out := make(nodeIndexSet, len(set))
+	for i := range set {
+		out[i] = struct{}{}
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeaffinity
+
+import (
+	"sort"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func node(name string, labels map[string]string) *v1.Node {
+	return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func nodeNames(nodes []*v1.Node) []string {
+	out := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		out = append(out, n.Name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestIndexMatching(t *testing.T) {
+	nodes := []*v1.Node{
+		node("a", map[string]string{"zone": "us-east-1a", "gpu": "true"}),
+		node("b", map[string]string{"zone": "us-east-1b"}),
+		node("c", map[string]string{"zone": "us-east-1a"}),
+	}
+	idx := NewIndex(nodes)
+
+	sel, err := NewNodeSelector(&v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
+		{MatchExpressions: []v1.NodeSelectorRequirement{
+			{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"us-east-1a"}},
+			{Key: "gpu", Operator: v1.NodeSelectorOpExists},
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("NewNodeSelector() returned error: %v", err)
+	}
+	got := nodeNames(idx.Matching(sel))
+	if len(got) != 1 || got[0] != "a" {
+		t.Errorf("expected only node a to match, got %v", got)
+	}
+}
+
+func TestIndexMatchingNotInAndDoesNotExist(t *testing.T) {
+	nodes := []*v1.Node{
+		node("a", map[string]string{"zone": "us-east-1a"}),
+		node("b", map[string]string{"zone": "us-east-1b"}),
+		node("c", nil),
+	}
+	idx := NewIndex(nodes)
+
+	sel, err := NewNodeSelector(&v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
+		{MatchExpressions: []v1.NodeSelectorRequirement{
+			{Key: "zone", Operator: v1.NodeSelectorOpDoesNotExist},
+		}},
+		{MatchExpressions: []v1.NodeSelectorRequirement{
+			{Key: "zone", Operator: v1.NodeSelectorOpNotIn, Values: []string{"us-east-1a"}},
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("NewNodeSelector() returned error: %v", err)
+	}
+	got := nodeNames(idx.Matching(sel))
+	if len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Errorf("expected nodes b and c to match, got %v", got)
+	}
+}
+
+func TestIndexScoring(t *testing.T) {
+	nodes := []*v1.Node{
+		node("a", map[string]string{"zone": "us-east-1a"}),
+		node("b", map[string]string{"zone": "us-east-1b"}),
+	}
+	idx := NewIndex(nodes)
+	terms, err := NewPreferredSchedulingTerms([]v1.PreferredSchedulingTerm{
+		{Weight: 10, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
+			{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"us-east-1a"}},
+		}}},
+	})
+	if err != nil {
+		t.Fatalf("NewPreferredSchedulingTerms() returned error: %v", err)
+	}
+	scores := idx.Scoring(terms)
+	byName := map[string]int64{}
+	for _, s := range scores {
+		byName[s.Node.Name] = s.Score
+	}
+	if byName["a"] != 10 || byName["b"] != 0 {
+		t.Errorf("unexpected scores: %v", byName)
+	}
+}
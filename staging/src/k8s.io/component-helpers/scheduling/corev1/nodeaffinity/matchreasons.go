@@ -0,0 +1,340 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeaffinity
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// LazyErrorNodeSelector is a runtime representation of v1.NodeSelector that
+// defers term validation to match time instead of rejecting the whole
+// selector up front: a selector with one invalid term still produces a
+// useful result for its other terms. Prefer NewNodeSelector when
+// construction-time validation is what's wanted.
+type LazyErrorNodeSelector struct {
+	terms []nodeSelectorTerm
+}
+
+// NewLazyErrorNodeSelector returns a LazyErrorNodeSelector for ns. Unlike
+// NewNodeSelector it never returns an error; a term that fails to parse is
+// treated as not matching by Match, and its parsing error is reported in
+// that term's result from MatchWithReasons.
+func NewLazyErrorNodeSelector(ns *v1.NodeSelector, opts ...field.PathOption) *LazyErrorNodeSelector {
+	p := field.ToPath(opts...)
+	parsedTerms := make([]nodeSelectorTerm, 0, len(ns.NodeSelectorTerms))
+	for i, term := range ns.NodeSelectorTerms {
+		if isEmptyNodeSelectorTerm(&term) {
+			continue
+		}
+		parsedTerm, errs := newNodeSelectorTerm(&term, p.Child("nodeSelectorTerms").Index(i))
+		parsedTerm.parsingErr = errs.ToAggregate()
+		parsedTerms = append(parsedTerms, parsedTerm)
+	}
+	return &LazyErrorNodeSelector{terms: parsedTerms}
+}
+
+// Match checks whether the node labels and fields match the selector terms,
+// treating any term with a deferred parsing error as not matching.
+func (ns *LazyErrorNodeSelector) Match(node *v1.Node) bool {
+	if node == nil {
+		return false
+	}
+	nodeLabels := labels.Set(node.Labels)
+	nodeFields := extractNodeFields(node)
+	for i := range ns.terms {
+		if ns.terms[i].match(nodeLabels, nodeFields) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchWithReasons behaves like Match, but additionally returns a MatchResult
+// recording, for every term, whether it matched, which requirement failed if
+// it didn't, and the term's deferred parsing error if it has one.
+func (ns *LazyErrorNodeSelector) MatchWithReasons(node *v1.Node) (MatchResult, error) {
+	return matchTermsWithReasons(ns.terms, node)
+}
+
+// MatchWithReasons behaves like Match, but additionally returns a MatchResult
+// recording, for every term, whether it matched and which requirement failed
+// if it didn't.
+func (ns *NodeSelector) MatchWithReasons(node *v1.Node) (MatchResult, error) {
+	return matchTermsWithReasons(ns.terms, node)
+}
+
+func matchTermsWithReasons(terms []nodeSelectorTerm, node *v1.Node) (MatchResult, error) {
+	if node == nil {
+		return MatchResult{}, nil
+	}
+	nodeLabels := labels.Set(node.Labels)
+	nodeFields := extractNodeFields(node)
+	result := MatchResult{Terms: make([]TermMatchResult, 0, len(terms))}
+	for i := range terms {
+		result.Terms = append(result.Terms, matchTermWithReasons(i, &terms[i], nodeLabels, nodeFields))
+	}
+	return result, nil
+}
+
+func matchTermWithReasons(index int, term *nodeSelectorTerm, nodeLabels labels.Set, nodeFields fields.Set) TermMatchResult {
+	if term.parsingErr != nil {
+		return TermMatchResult{Index: index, ParsingError: term.parsingErr}
+	}
+	if term.matchLabels != nil {
+		if reqs, selectable := term.matchLabels.Requirements(); selectable {
+			for _, req := range reqs {
+				if !req.Matches(nodeLabels) {
+					actual, exists := nodeLabels[req.Key()]
+					return TermMatchResult{Index: index, FailedRequirement: &RequirementMatchResult{
+						Key:          req.Key(),
+						Operator:     nodeSelectorOperatorForLabels(req.Operator()),
+						Values:       req.Values().List(),
+						ActualValue:  actual,
+						ActualExists: exists,
+					}}
+				}
+			}
+		} else {
+			return TermMatchResult{Index: index}
+		}
+	}
+	if term.matchFields != nil {
+		for _, req := range term.matchFields.Requirements() {
+			actual, exists := nodeFields[req.Field]
+			if !fieldRequirementMatches(req, actual) {
+				return TermMatchResult{Index: index, FailedRequirement: &RequirementMatchResult{
+					Key:          req.Field,
+					Operator:     nodeSelectorOperatorForFields(req.Operator),
+					Values:       []string{req.Value},
+					ActualValue:  actual,
+					ActualExists: exists,
+				}}
+			}
+		}
+	}
+	return TermMatchResult{Index: index, Matched: true}
+}
+
+func fieldRequirementMatches(req fields.Requirement, actual string) bool {
+	switch req.Operator {
+	case selection.NotEquals:
+		return actual != req.Value
+	default:
+		return actual == req.Value
+	}
+}
+
+func nodeSelectorOperatorForLabels(op selection.Operator) v1.NodeSelectorOperator {
+	switch op {
+	case selection.In:
+		return v1.NodeSelectorOpIn
+	case selection.NotIn:
+		return v1.NodeSelectorOpNotIn
+	case selection.Exists:
+		return v1.NodeSelectorOpExists
+	case selection.DoesNotExist:
+		return v1.NodeSelectorOpDoesNotExist
+	case selection.GreaterThan:
+		return v1.NodeSelectorOpGt
+	case selection.LessThan:
+		return v1.NodeSelectorOpLt
+	default:
+		return v1.NodeSelectorOperator(op)
+	}
+}
+
+func nodeSelectorOperatorForFields(op selection.Operator) v1.NodeSelectorOperator {
+	if op == selection.NotEquals {
+		return v1.NodeSelectorOpNotIn
+	}
+	return v1.NodeSelectorOpIn
+}
+
+// RequirementMatchResult is a diagnostic snapshot of the NodeSelectorRequirement
+// that caused a term not to match: the requirement itself, plus the node's
+// actual value (if any) for the same key or field.
+type RequirementMatchResult struct {
+	Key          string
+	Operator     v1.NodeSelectorOperator
+	Values       []string
+	ActualValue  string
+	ActualExists bool
+}
+
+func (r *RequirementMatchResult) String() string {
+	actual := "<unset>"
+	if r.ActualExists {
+		actual = r.ActualValue
+	}
+	return fmt.Sprintf("%s %s %v (actual: %s)", r.Key, r.Operator, r.Values, actual)
+}
+
+// TermMatchResult is the outcome of evaluating a single NodeSelectorTerm.
+type TermMatchResult struct {
+	Index             int
+	Matched           bool
+	FailedRequirement *RequirementMatchResult
+	ParsingError      error
+}
+
+func (r TermMatchResult) String() string {
+	switch {
+	case r.ParsingError != nil:
+		return fmt.Sprintf("term[%d]: invalid, %v", r.Index, r.ParsingError)
+	case r.Matched:
+		return fmt.Sprintf("term[%d]: matched", r.Index)
+	case r.FailedRequirement != nil:
+		return fmt.Sprintf("term[%d]: did not match, failed requirement %s", r.Index, r.FailedRequirement)
+	default:
+		return fmt.Sprintf("term[%d]: did not match", r.Index)
+	}
+}
+
+// MarshalJSON renders r as {"term": n, "matched": bool, "failedRequirement": {...}}.
+func (r TermMatchResult) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Term              int                     `json:"term"`
+		Matched           bool                    `json:"matched"`
+		FailedRequirement *RequirementMatchResult `json:"failedRequirement,omitempty"`
+		ParsingError      string                  `json:"parsingError,omitempty"`
+	}{
+		Term:              r.Index,
+		Matched:           r.Matched,
+		FailedRequirement: r.FailedRequirement,
+	}
+	if r.ParsingError != nil {
+		out.ParsingError = r.ParsingError.Error()
+	}
+	return json.Marshal(out)
+}
+
+// MatchResult is the outcome of evaluating every term of a NodeSelector or
+// LazyErrorNodeSelector against a node.
+type MatchResult struct {
+	Terms []TermMatchResult
+}
+
+// Matched reports whether any term matched, matching the semantics of
+// NodeSelector.Match/LazyErrorNodeSelector.Match.
+func (mr MatchResult) Matched() bool {
+	for _, t := range mr.Terms {
+		if t.Matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (mr MatchResult) String() string {
+	parts := make([]string, 0, len(mr.Terms))
+	for _, t := range mr.Terms {
+		parts = append(parts, t.String())
+	}
+	return strings.Join(parts, "; ")
+}
+
+// TermScoreResult is the outcome of evaluating a single PreferredSchedulingTerm.
+type TermScoreResult struct {
+	Index             int
+	Weight            int32
+	Matched           bool
+	FailedRequirement *RequirementMatchResult
+	ParsingError      error
+}
+
+func (r TermScoreResult) String() string {
+	switch {
+	case r.ParsingError != nil:
+		return fmt.Sprintf("term[%d] (weight %d): invalid, %v", r.Index, r.Weight, r.ParsingError)
+	case r.Matched:
+		return fmt.Sprintf("term[%d] (weight %d): matched", r.Index, r.Weight)
+	case r.FailedRequirement != nil:
+		return fmt.Sprintf("term[%d] (weight %d): did not match, failed requirement %s", r.Index, r.Weight, r.FailedRequirement)
+	default:
+		return fmt.Sprintf("term[%d] (weight %d): did not match", r.Index, r.Weight)
+	}
+}
+
+// MarshalJSON renders r as {"term": n, "weight": w, "matched": bool, "failedRequirement": {...}}.
+func (r TermScoreResult) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Term              int                     `json:"term"`
+		Weight            int32                   `json:"weight"`
+		Matched           bool                    `json:"matched"`
+		FailedRequirement *RequirementMatchResult `json:"failedRequirement,omitempty"`
+		ParsingError      string                  `json:"parsingError,omitempty"`
+	}{
+		Term:              r.Index,
+		Weight:            r.Weight,
+		Matched:           r.Matched,
+		FailedRequirement: r.FailedRequirement,
+	}
+	if r.ParsingError != nil {
+		out.ParsingError = r.ParsingError.Error()
+	}
+	return json.Marshal(out)
+}
+
+// ScoreResult is the outcome of evaluating every term of a
+// PreferredSchedulingTerms against a node.
+type ScoreResult struct {
+	Score int64
+	Terms []TermScoreResult
+}
+
+func (sr ScoreResult) String() string {
+	parts := make([]string, 0, len(sr.Terms))
+	for _, t := range sr.Terms {
+		parts = append(parts, t.String())
+	}
+	return fmt.Sprintf("score %d: %s", sr.Score, strings.Join(parts, "; "))
+}
+
+// ScoreWithReasons behaves like Score, but additionally returns a ScoreResult
+// recording, for every term, whether it matched and which requirement failed
+// if it didn't.
+func (t *PreferredSchedulingTerms) ScoreWithReasons(node *v1.Node) (ScoreResult, error) {
+	if node == nil {
+		return ScoreResult{}, nil
+	}
+	nodeLabels := labels.Set(node.Labels)
+	nodeFields := extractNodeFields(node)
+	result := ScoreResult{Terms: make([]TermScoreResult, 0, len(t.terms))}
+	for i, term := range t.terms {
+		termResult := matchTermWithReasons(i, &term.nodeSelectorTerm, nodeLabels, nodeFields)
+		scoreResult := TermScoreResult{
+			Index:             i,
+			Weight:            term.weight,
+			Matched:           termResult.Matched,
+			FailedRequirement: termResult.FailedRequirement,
+			ParsingError:      termResult.ParsingError,
+		}
+		if scoreResult.Matched {
+			result.Score += int64(term.weight)
+		}
+		result.Terms = append(result.Terms, scoreResult)
+	}
+	return result, nil
+}
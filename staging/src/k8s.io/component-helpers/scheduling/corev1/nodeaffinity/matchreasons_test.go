@@ -0,0 +1,96 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeaffinity
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeSelectorMatchWithReasons(t *testing.T) {
+	ns, err := NewNodeSelector(&v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
+		{MatchExpressions: []v1.NodeSelectorRequirement{{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"us-east-1a"}}}},
+	}})
+	if err != nil {
+		t.Fatalf("NewNodeSelector() returned error: %v", err)
+	}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "n1", Labels: map[string]string{"zone": "us-west-2a"}}}
+	result, err := ns.MatchWithReasons(node)
+	if err != nil {
+		t.Fatalf("MatchWithReasons() returned error: %v", err)
+	}
+	if result.Matched() {
+		t.Fatalf("expected no match")
+	}
+	if len(result.Terms) != 1 || result.Terms[0].FailedRequirement == nil {
+		t.Fatalf("expected a failed requirement, got %+v", result.Terms)
+	}
+	fr := result.Terms[0].FailedRequirement
+	if fr.Key != "zone" || fr.ActualValue != "us-west-2a" || !fr.ActualExists {
+		t.Errorf("unexpected failed requirement: %+v", fr)
+	}
+}
+
+func TestLazyErrorNodeSelectorDefersInvalidTerms(t *testing.T) {
+	ns := NewLazyErrorNodeSelector(&v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
+		{MatchExpressions: []v1.NodeSelectorRequirement{{Key: "invalid key", Operator: v1.NodeSelectorOpIn, Values: []string{"x"}}}},
+		{MatchExpressions: []v1.NodeSelectorRequirement{{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"us-east-1a"}}}},
+	}})
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "n1", Labels: map[string]string{"zone": "us-east-1a"}}}
+	if !ns.Match(node) {
+		t.Fatalf("expected the valid second term to match despite the first term being invalid")
+	}
+	result, err := ns.MatchWithReasons(node)
+	if err != nil {
+		t.Fatalf("MatchWithReasons() returned error: %v", err)
+	}
+	if len(result.Terms) != 2 {
+		t.Fatalf("expected 2 terms, got %d", len(result.Terms))
+	}
+	if result.Terms[0].ParsingError == nil {
+		t.Errorf("expected term 0 to carry a parsing error")
+	}
+	if !result.Terms[1].Matched {
+		t.Errorf("expected term 1 to match")
+	}
+}
+
+func TestPreferredSchedulingTermsScoreWithReasons(t *testing.T) {
+	terms, err := NewPreferredSchedulingTerms([]v1.PreferredSchedulingTerm{
+		{Weight: 5, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"us-east-1a"}}}}},
+		{Weight: 7, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"us-west-2a"}}}}},
+	})
+	if err != nil {
+		t.Fatalf("NewPreferredSchedulingTerms() returned error: %v", err)
+	}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "n1", Labels: map[string]string{"zone": "us-east-1a"}}}
+	result, err := terms.ScoreWithReasons(node)
+	if err != nil {
+		t.Fatalf("ScoreWithReasons() returned error: %v", err)
+	}
+	if result.Score != 5 {
+		t.Errorf("expected score 5, got %d", result.Score)
+	}
+	if !result.Terms[0].Matched || result.Terms[1].Matched {
+		t.Errorf("unexpected term results: %+v", result.Terms)
+	}
+	if result.Terms[1].FailedRequirement == nil || result.Terms[1].FailedRequirement.ActualValue != "us-east-1a" {
+		t.Errorf("expected term 1 failed requirement to report actual zone, got %+v", result.Terms[1].FailedRequirement)
+	}
+}
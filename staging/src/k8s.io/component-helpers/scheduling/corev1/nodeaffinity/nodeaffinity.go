@@ -0,0 +1,262 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package nodeaffinity has library functions for evaluating the NodeAffinity
+// and NodeSelector terms of a v1.Pod against v1.Node objects, without
+// depending on the scheduler's internal types.
+package nodeaffinity
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// NodeSelector is a runtime representation of v1.NodeSelector.
+type NodeSelector struct {
+	terms []nodeSelectorTerm
+}
+
+// NewNodeSelector returns a NodeSelector for nodeSelector, or an error if any
+// term of nodeSelector is invalid.
+func NewNodeSelector(ns *v1.NodeSelector, opts ...field.PathOption) (*NodeSelector, error) {
+	p := field.ToPath(opts...)
+	parsedTerms := make([]nodeSelectorTerm, 0, len(ns.NodeSelectorTerms))
+	var allErrs field.ErrorList
+	for i, term := range ns.NodeSelectorTerms {
+		// nil or empty term selects no objects
+		if isEmptyNodeSelectorTerm(&term) {
+			continue
+		}
+		termPath := p.Child("nodeSelectorTerms").Index(i)
+		parsedTerm, errs := newNodeSelectorTerm(&term, termPath)
+		allErrs = append(allErrs, errs...)
+		parsedTerms = append(parsedTerms, parsedTerm)
+	}
+	if err := allErrs.ToAggregate(); err != nil {
+		return nil, err
+	}
+	return &NodeSelector{terms: parsedTerms}, nil
+}
+
+// Match checks whether the node labels and fields match the selector terms.
+// A nil node never matches. An empty (nil or zero-term) selector never matches.
+func (ns *NodeSelector) Match(node *v1.Node) bool {
+	if ns == nil || node == nil {
+		return false
+	}
+	nodeLabels := labels.Set(node.Labels)
+	nodeFields := extractNodeFields(node)
+	for _, term := range ns.terms {
+		if term.match(nodeLabels, nodeFields) {
+			return true
+		}
+	}
+	return false
+}
+
+// PreferredSchedulingTerms is a runtime representation of []v1.PreferredSchedulingTerm.
+type PreferredSchedulingTerms struct {
+	terms []preferredSchedulingTerm
+}
+
+type preferredSchedulingTerm struct {
+	nodeSelectorTerm
+	weight int32
+}
+
+// NewPreferredSchedulingTerms returns a PreferredSchedulingTerms for the given
+// terms, or an error if any of the terms with a non-zero weight is invalid.
+// Terms with a weight of 0 are dropped, mirroring the fact that they can
+// never affect the score.
+func NewPreferredSchedulingTerms(terms []v1.PreferredSchedulingTerm, opts ...field.PathOption) (*PreferredSchedulingTerms, error) {
+	p := field.ToPath(opts...)
+	parsedTerms := make([]preferredSchedulingTerm, 0, len(terms))
+	var allErrs field.ErrorList
+	for i, term := range terms {
+		if term.Weight == 0 || isEmptyNodeSelectorTerm(&term.Preference) {
+			continue
+		}
+		termPath := p.Index(i)
+		parsedTerm, errs := newNodeSelectorTerm(&term.Preference, termPath)
+		allErrs = append(allErrs, errs...)
+		parsedTerms = append(parsedTerms, preferredSchedulingTerm{nodeSelectorTerm: parsedTerm, weight: term.Weight})
+	}
+	if err := allErrs.ToAggregate(); err != nil {
+		return nil, err
+	}
+	return &PreferredSchedulingTerms{terms: parsedTerms}, nil
+}
+
+// Score returns a sum of the weights of the terms that match the node.
+func (t *PreferredSchedulingTerms) Score(node *v1.Node) int64 {
+	var score int64
+	nodeLabels := labels.Set(node.Labels)
+	nodeFields := extractNodeFields(node)
+	for _, term := range t.terms {
+		if term.match(nodeLabels, nodeFields) {
+			score += int64(term.weight)
+		}
+	}
+	return score
+}
+
+// nodeSelectorTerm is the parsed form of a v1.NodeSelectorTerm. parsingErr is
+// only ever set by LazyErrorNodeSelector, which defers term validation to
+// match time instead of rejecting the whole selector up front.
+type nodeSelectorTerm struct {
+	matchLabels labels.Selector
+	matchFields fields.Selector
+	parsingErr  error
+}
+
+// newNodeSelectorTerm parses term into its labels.Selector / fields.Selector
+// representation, returning every validation error found along the way
+// rooted at path.
+func newNodeSelectorTerm(term *v1.NodeSelectorTerm, path *field.Path) (nodeSelectorTerm, field.ErrorList) {
+	var parsedTerm nodeSelectorTerm
+	var allErrs field.ErrorList
+	if len(term.MatchExpressions) != 0 {
+		var errs field.ErrorList
+		parsedTerm.matchLabels, errs = nodeSelectorRequirementsAsSelector(term.MatchExpressions, path.Child("matchExpressions"))
+		allErrs = append(allErrs, errs...)
+	}
+	if len(term.MatchFields) != 0 {
+		var errs field.ErrorList
+		parsedTerm.matchFields, errs = nodeSelectorRequirementsAsFieldSelector(term.MatchFields, path.Child("matchFields"))
+		allErrs = append(allErrs, errs...)
+	}
+	return parsedTerm, allErrs
+}
+
+func (t *nodeSelectorTerm) match(nodeLabels labels.Set, nodeFields fields.Set) bool {
+	if t.parsingErr != nil {
+		return false
+	}
+	if t.matchLabels != nil && !t.matchLabels.Matches(nodeLabels) {
+		return false
+	}
+	if t.matchFields != nil && len(nodeFields) > 0 && !t.matchFields.Matches(nodeFields) {
+		return false
+	}
+	return true
+}
+
+func isEmptyNodeSelectorTerm(term *v1.NodeSelectorTerm) bool {
+	return len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0
+}
+
+// extractNodeFields builds the field.Set a NodeSelectorTerm's MatchFields
+// can be evaluated against. Only metadata.name is currently supported, which
+// matches the set of node fields the API server accepts in a field selector.
+func extractNodeFields(node *v1.Node) fields.Set {
+	return fields.Set{
+		"metadata.name": node.Name,
+	}
+}
+
+// nodeSelectorRequirementsAsSelector converts the []NodeSelectorRequirement
+// to a labels.Selector.
+func nodeSelectorRequirementsAsSelector(nsr []v1.NodeSelectorRequirement, path *field.Path) (labels.Selector, field.ErrorList) {
+	if len(nsr) == 0 {
+		return labels.Nothing(), nil
+	}
+	var allErrs field.ErrorList
+	selector := labels.NewSelector()
+	for i, expr := range nsr {
+		p := path.Index(i)
+		if errs := utilvalidation.IsQualifiedName(expr.Key); len(errs) != 0 {
+			allErrs = append(allErrs, field.Invalid(p, expr, fmt.Sprintf("invalid label key %q: %s", expr.Key, strings.Join(errs, "; "))))
+			continue
+		}
+		var op selection.Operator
+		switch expr.Operator {
+		case v1.NodeSelectorOpIn:
+			op = selection.In
+		case v1.NodeSelectorOpNotIn:
+			op = selection.NotIn
+		case v1.NodeSelectorOpExists:
+			op = selection.Exists
+		case v1.NodeSelectorOpDoesNotExist:
+			op = selection.DoesNotExist
+		case v1.NodeSelectorOpGt:
+			op = selection.GreaterThan
+		case v1.NodeSelectorOpLt:
+			op = selection.LessThan
+		default:
+			allErrs = append(allErrs, field.NotSupported(p.Child("operator"), expr.Operator, []string{
+				string(v1.NodeSelectorOpIn), string(v1.NodeSelectorOpNotIn),
+				string(v1.NodeSelectorOpExists), string(v1.NodeSelectorOpDoesNotExist),
+				string(v1.NodeSelectorOpGt), string(v1.NodeSelectorOpLt),
+			}))
+			continue
+		}
+		if (op == selection.Exists || op == selection.DoesNotExist) && len(expr.Values) != 0 {
+			allErrs = append(allErrs, field.Invalid(p, nil, "values set must be empty for exists and does not exist"))
+			continue
+		}
+		r, err := labels.NewRequirement(expr.Key, op, expr.Values)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(p, expr, err.Error()))
+			continue
+		}
+		selector = selector.Add(*r)
+	}
+	if len(allErrs) != 0 {
+		return nil, allErrs
+	}
+	return selector, nil
+}
+
+// nodeSelectorRequirementsAsFieldSelector converts the []NodeSelectorRequirement
+// to a fields.Selector. Only metadata.name with In/NotIn and a single value is
+// supported, matching the node fields the API server indexes.
+func nodeSelectorRequirementsAsFieldSelector(nsr []v1.NodeSelectorRequirement, path *field.Path) (fields.Selector, field.ErrorList) {
+	if len(nsr) == 0 {
+		return fields.Nothing(), nil
+	}
+	var allErrs field.ErrorList
+	selectors := []fields.Selector{}
+	for i, expr := range nsr {
+		p := path.Index(i)
+		switch expr.Operator {
+		case v1.NodeSelectorOpIn:
+			if len(expr.Values) != 1 {
+				allErrs = append(allErrs, field.Invalid(p.Child("values"), expr.Values, "must have one element"))
+				continue
+			}
+			selectors = append(selectors, fields.OneTermEqualSelector(expr.Key, expr.Values[0]))
+		case v1.NodeSelectorOpNotIn:
+			if len(expr.Values) != 1 {
+				allErrs = append(allErrs, field.Invalid(p.Child("values"), expr.Values, "must have one element"))
+				continue
+			}
+			selectors = append(selectors, fields.OneTermNotEqualSelector(expr.Key, expr.Values[0]))
+		default:
+			allErrs = append(allErrs, field.NotSupported(p.Child("operator"), expr.Operator, []string{string(v1.NodeSelectorOpIn), string(v1.NodeSelectorOpNotIn)}))
+		}
+	}
+	if len(allErrs) != 0 {
+		return nil, allErrs
+	}
+	return fields.AndSelectors(selectors...), nil
+}
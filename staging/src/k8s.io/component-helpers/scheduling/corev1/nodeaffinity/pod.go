@@ -0,0 +1,72 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeaffinity
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// NewNodeSelectorFromPod builds a NodeSelector from term, expanding
+// matchLabelKeys/mismatchLabelKeys the same way pod topology spread and pod
+// affinity do: every key in matchLabelKeys contributes a synthetic `In`
+// requirement pinned to the pod's own value for that label, and every key in
+// mismatchLabelKeys contributes a synthetic `NotIn` requirement with the same
+// value. A key missing from pod.Labels is a field.Error rather than a
+// silently-empty requirement.
+//
+// v1.NodeSelectorTerm does not itself carry MatchLabelKeys/MismatchLabelKeys
+// fields (unlike v1.PodAffinityTerm, which gained them for pod topology
+// spread), so this takes the key lists as explicit arguments rather than
+// reading them off term.
+func NewNodeSelectorFromPod(pod *v1.Pod, term *v1.NodeSelectorTerm, matchLabelKeys, mismatchLabelKeys []string) (*NodeSelector, error) {
+	expanded := *term
+	expanded.MatchExpressions = append([]v1.NodeSelectorRequirement{}, term.MatchExpressions...)
+
+	var allErrs field.ErrorList
+	for i, key := range matchLabelKeys {
+		value, ok := pod.Labels[key]
+		if !ok {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("matchLabelKeys").Index(i), key, fmt.Sprintf("no value for key %q in pod labels", key)))
+			continue
+		}
+		expanded.MatchExpressions = append(expanded.MatchExpressions, v1.NodeSelectorRequirement{
+			Key:      key,
+			Operator: v1.NodeSelectorOpIn,
+			Values:   []string{value},
+		})
+	}
+	for i, key := range mismatchLabelKeys {
+		value, ok := pod.Labels[key]
+		if !ok {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("mismatchLabelKeys").Index(i), key, fmt.Sprintf("no value for key %q in pod labels", key)))
+			continue
+		}
+		expanded.MatchExpressions = append(expanded.MatchExpressions, v1.NodeSelectorRequirement{
+			Key:      key,
+			Operator: v1.NodeSelectorOpNotIn,
+			Values:   []string{value},
+		})
+	}
+	if err := allErrs.ToAggregate(); err != nil {
+		return nil, err
+	}
+
+	return NewNodeSelector(&v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{expanded}})
+}
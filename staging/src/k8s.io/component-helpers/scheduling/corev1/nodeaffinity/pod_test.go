@@ -0,0 +1,52 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeaffinity
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewNodeSelectorFromPod(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+		"team": "payments",
+		"env":  "prod",
+	}}}
+
+	t.Run("expands matchLabelKeys and mismatchLabelKeys", func(t *testing.T) {
+		ns, err := NewNodeSelectorFromPod(pod, &v1.NodeSelectorTerm{}, []string{"team"}, []string{"env"})
+		if err != nil {
+			t.Fatalf("NewNodeSelectorFromPod() returned error: %v", err)
+		}
+		match := &v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"team": "payments", "env": "staging"}}}
+		if !ns.Match(match) {
+			t.Errorf("expected node with team=payments, env!=prod to match")
+		}
+		noMatch := &v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"team": "payments", "env": "prod"}}}
+		if ns.Match(noMatch) {
+			t.Errorf("expected node with env=prod to be excluded by mismatchLabelKeys")
+		}
+	})
+
+	t.Run("missing key is an error", func(t *testing.T) {
+		if _, err := NewNodeSelectorFromPod(pod, &v1.NodeSelectorTerm{}, []string{"missing"}, nil); err == nil {
+			t.Fatalf("expected an error for a matchLabelKeys entry missing from pod labels")
+		}
+	})
+}
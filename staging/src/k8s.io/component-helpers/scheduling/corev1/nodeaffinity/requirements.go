@@ -0,0 +1,367 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeaffinity
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Sentinel elements stored inside a Requirements value set to represent
+// operators that don't reduce to a concrete, enumerable list of strings.
+// A NotIn value is stored as its negation so that a single sets.Set[string]
+// can carry both "must be one of" and "must not be one of" constraints.
+const (
+	valueExists       = "{exists}"
+	valueDoesNotExist = "{doesnotexist}"
+	notInPrefix       = "!"
+	gtPrefix          = "{gt}"
+	ltPrefix          = "{lt}"
+	// valueImpossible marks a key whose requirements have already been found
+	// mutually exclusive (e.g. Gt "4" and Lt "3"). It must propagate through
+	// further insert/Intersect calls on that key rather than being discarded,
+	// otherwise a later call that only looks at a bare empty set would treat
+	// the key as unconstrained again and resurrect it.
+	valueImpossible = "{impossible}"
+)
+
+// Requirements is a flattened, per-key view of a v1.NodeSelector: for every
+// key referenced by any requirement, it records the set of values that
+// satisfy every requirement seen for that key. It is the same shape as
+// Karpenter's scheduling.Requirements, and is meant for combining a pod's
+// NodeAffinity with a controller's own baseline affinity and checking
+// compatibility ahead of time, rather than evaluating each candidate node in
+// turn with NodeSelector.Match.
+//
+// Requirements ANDs together every term of the source NodeSelector, rather
+// than preserving its OR-of-terms semantics. That is the right model for a
+// single candidate (a provisioner's labels, a pod's own requirements) but
+// does not capture the full disjunctive meaning of a multi-term
+// NodeSelector; callers that need exact OR semantics should build one
+// Requirements per term.
+type Requirements map[string]sets.Set[string]
+
+// NewRequirements flattens nodeSelector into a Requirements. A nil
+// nodeSelector returns an empty Requirements, which imposes no constraints
+// and is compatible with any Requirements that can itself be satisfied.
+func NewRequirements(nodeSelector *v1.NodeSelector) Requirements {
+	r := Requirements{}
+	if nodeSelector == nil {
+		return r
+	}
+	for _, term := range nodeSelector.NodeSelectorTerms {
+		for _, req := range term.MatchExpressions {
+			r.insert(req)
+		}
+		for _, req := range term.MatchFields {
+			r.insert(req)
+		}
+	}
+	return r
+}
+
+func (r Requirements) insert(req v1.NodeSelectorRequirement) {
+	values, ok := requirementValues(req)
+	if !ok {
+		// Malformed requirement (e.g. Gt/Lt with no value) or unknown
+		// operator: ignore it rather than panic, so it adds no constraint
+		// on the key.
+		return
+	}
+	if existing, ok := r[req.Key]; ok {
+		values = intersectValues(existing, values)
+	}
+	r[req.Key] = values
+}
+
+func requirementValues(req v1.NodeSelectorRequirement) (sets.Set[string], bool) {
+	switch req.Operator {
+	case v1.NodeSelectorOpIn:
+		return sets.New(req.Values...), true
+	case v1.NodeSelectorOpNotIn:
+		out := sets.New[string]()
+		for _, v := range req.Values {
+			out.Insert(notInPrefix + v)
+		}
+		return out, true
+	case v1.NodeSelectorOpExists:
+		return sets.New(valueExists), true
+	case v1.NodeSelectorOpDoesNotExist:
+		return sets.New(valueDoesNotExist), true
+	case v1.NodeSelectorOpGt:
+		if len(req.Values) == 0 {
+			return nil, false
+		}
+		return sets.New(gtPrefix + req.Values[0]), true
+	case v1.NodeSelectorOpLt:
+		if len(req.Values) == 0 {
+			return nil, false
+		}
+		return sets.New(ltPrefix + req.Values[0]), true
+	default:
+		return nil, false
+	}
+}
+
+// Intersect returns a new Requirements holding, for every key present in
+// either r or other, the values compatible with both. Keys present in only
+// one side pass through unchanged.
+func (r Requirements) Intersect(other Requirements) Requirements {
+	out := Requirements{}
+	for key, values := range r {
+		out[key] = values.Clone()
+	}
+	for key, values := range other {
+		if existing, ok := out[key]; ok {
+			out[key] = intersectValues(existing, values)
+		} else {
+			out[key] = values.Clone()
+		}
+	}
+	return out
+}
+
+// Compatible reports whether r and other can be satisfied simultaneously. It
+// returns an error identifying the first key whose combined value set is
+// empty, i.e. for which no value satisfies both sides.
+func (r Requirements) Compatible(other Requirements) error {
+	merged := r.Intersect(other)
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if merged[key].Has(valueImpossible) || merged[key].Len() == 0 {
+			return fmt.Errorf("key %q: no value satisfies both sets of requirements", key)
+		}
+	}
+	return nil
+}
+
+// Keys returns the sorted set of keys r has requirements for.
+func (r Requirements) Keys() []string {
+	keys := make([]string, 0, len(r))
+	for key := range r {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Values returns the concrete, enumerable values known to satisfy key. For
+// Exists, DoesNotExist, Gt and Lt requirements, which do not reduce to an
+// enumerable list, it returns an empty set; use Has to test a specific
+// value instead.
+func (r Requirements) Values(key string) sets.Set[string] {
+	out := sets.New[string]()
+	for v := range r[key] {
+		if strings.HasPrefix(v, notInPrefix) || v == valueExists || v == valueDoesNotExist || v == valueImpossible || strings.HasPrefix(v, gtPrefix) || strings.HasPrefix(v, ltPrefix) {
+			continue
+		}
+		out.Insert(v)
+	}
+	return out
+}
+
+// Has reports whether value satisfies every requirement recorded for key. A
+// key with no requirements is unconstrained and is compatible with any
+// value.
+func (r Requirements) Has(key, value string) bool {
+	values, ok := r[key]
+	if !ok {
+		return true
+	}
+	return matches(values, value)
+}
+
+func matches(values sets.Set[string], value string) bool {
+	c := classify(values)
+	if c.impossible || c.doesNotExist {
+		return false
+	}
+	if c.excluded.Has(value) {
+		return false
+	}
+	if len(c.allowed) > 0 && !c.allowed.Has(value) {
+		return false
+	}
+	if c.lowerBound != nil || c.upperBound != nil {
+		// A value that isn't a number can't satisfy a Gt/Lt bound.
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		if c.lowerBound != nil && n <= *c.lowerBound {
+			return false
+		}
+		if c.upperBound != nil && n >= *c.upperBound {
+			return false
+		}
+	}
+	return true
+}
+
+// classification splits a value set produced by requirementValues/
+// intersectValues back into its constituent constraints.
+type classification struct {
+	allowed, excluded      sets.Set[string]
+	lowerBound, upperBound *float64
+	exists, doesNotExist   bool
+	impossible             bool
+}
+
+func classify(values sets.Set[string]) classification {
+	c := classification{allowed: sets.New[string](), excluded: sets.New[string]()}
+	if values.Has(valueImpossible) {
+		c.impossible = true
+		return c
+	}
+	for v := range values {
+		switch {
+		case v == valueExists:
+			c.exists = true
+		case v == valueDoesNotExist:
+			c.doesNotExist = true
+		case strings.HasPrefix(v, notInPrefix):
+			c.excluded.Insert(strings.TrimPrefix(v, notInPrefix))
+		case strings.HasPrefix(v, gtPrefix):
+			if n, err := strconv.ParseFloat(strings.TrimPrefix(v, gtPrefix), 64); err == nil {
+				if c.lowerBound == nil || n > *c.lowerBound {
+					c.lowerBound = &n
+				}
+			}
+		case strings.HasPrefix(v, ltPrefix):
+			if n, err := strconv.ParseFloat(strings.TrimPrefix(v, ltPrefix), 64); err == nil {
+				if c.upperBound == nil || n < *c.upperBound {
+					c.upperBound = &n
+				}
+			}
+		default:
+			c.allowed.Insert(v)
+		}
+	}
+	return c
+}
+
+// intersectValues combines the value sets of two requirements recorded for
+// the same key: per-key set intersection for In, complement handling for
+// NotIn, and numeric interval intersection for Gt/Lt.
+func intersectValues(a, b sets.Set[string]) sets.Set[string] {
+	ca := classify(a)
+	cb := classify(b)
+
+	// Impossible is terminal: once a key's requirements have been found
+	// mutually exclusive, every later insert/Intersect on that key must keep
+	// reporting it as impossible rather than re-deriving a fresh constraint
+	// from whichever side still looks unconstrained.
+	if ca.impossible || cb.impossible {
+		return sets.New(valueImpossible)
+	}
+
+	if ca.doesNotExist || cb.doesNotExist {
+		other := cb
+		if cb.doesNotExist {
+			other = ca
+		}
+		// An absent key satisfies DoesNotExist and any NotIn.
+		if other.doesNotExist || (!other.exists && len(other.allowed) == 0 && other.lowerBound == nil && other.upperBound == nil) {
+			return sets.New(valueDoesNotExist)
+		}
+		// DoesNotExist conflicts with any requirement that needs the key
+		// present (Exists, an allowed list, or a bound).
+		return sets.New(valueImpossible)
+	}
+
+	excluded := ca.excluded.Union(cb.excluded)
+
+	var lower, upper *float64
+	for _, n := range []*float64{ca.lowerBound, cb.lowerBound} {
+		if n != nil && (lower == nil || *n > *lower) {
+			lower = n
+		}
+	}
+	for _, n := range []*float64{ca.upperBound, cb.upperBound} {
+		if n != nil && (upper == nil || *n < *upper) {
+			upper = n
+		}
+	}
+	if lower != nil && upper != nil && *lower >= *upper {
+		return sets.New(valueImpossible)
+	}
+
+	var allowed sets.Set[string]
+	switch {
+	case ca.exists && cb.exists:
+		allowed = sets.New[string]()
+	case ca.exists:
+		allowed = cb.allowed
+	case cb.exists:
+		allowed = ca.allowed
+	case len(ca.allowed) > 0 && len(cb.allowed) > 0:
+		allowed = ca.allowed.Intersection(cb.allowed)
+		if allowed.Len() == 0 {
+			return sets.New(valueImpossible)
+		}
+	case len(ca.allowed) > 0:
+		allowed = ca.allowed
+	default:
+		allowed = cb.allowed
+	}
+
+	out := sets.New[string]()
+	if allowed.Len() > 0 {
+		for v := range allowed {
+			if excluded.Has(v) {
+				continue
+			}
+			if lower != nil || upper != nil {
+				n, err := strconv.ParseFloat(v, 64)
+				if err != nil || (lower != nil && n <= *lower) || (upper != nil && n >= *upper) {
+					continue
+				}
+			}
+			out.Insert(v)
+		}
+		if out.Len() == 0 {
+			return sets.New(valueImpossible)
+		}
+		return out
+	}
+	if ca.exists || cb.exists {
+		out.Insert(valueExists)
+	}
+	for v := range excluded {
+		out.Insert(notInPrefix + v)
+	}
+	if lower != nil {
+		out.Insert(gtPrefix + strconv.FormatFloat(*lower, 'g', -1, 64))
+	}
+	if upper != nil {
+		out.Insert(ltPrefix + strconv.FormatFloat(*upper, 'g', -1, 64))
+	}
+	if out.Len() == 0 {
+		// Both sides were empty/unconstrained placeholders; treat as exists.
+		out.Insert(valueExists)
+	}
+	return out
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeaffinity
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func selector(reqs ...v1.NodeSelectorRequirement) *v1.NodeSelector {
+	return &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{MatchExpressions: reqs}}}
+}
+
+func req(key string, op v1.NodeSelectorOperator, values ...string) v1.NodeSelectorRequirement {
+	return v1.NodeSelectorRequirement{Key: key, Operator: op, Values: values}
+}
+
+func TestRequirementsHas(t *testing.T) {
+	r := NewRequirements(selector(req("zone", v1.NodeSelectorOpIn, "us-east-1a", "us-east-1b")))
+	if !r.Has("zone", "us-east-1a") {
+		t.Errorf("expected zone=us-east-1a to be allowed")
+	}
+	if r.Has("zone", "us-west-2a") {
+		t.Errorf("expected zone=us-west-2a to be disallowed")
+	}
+	if !r.Has("instance-type", "m5.large") {
+		t.Errorf("expected unconstrained key to allow any value")
+	}
+}
+
+func TestRequirementsIntersectConflict(t *testing.T) {
+	a := NewRequirements(selector(req("zone", v1.NodeSelectorOpIn, "us-east-1a")))
+	b := NewRequirements(selector(req("zone", v1.NodeSelectorOpIn, "us-east-1b")))
+	if err := a.Compatible(b); err == nil {
+		t.Fatalf("expected incompatible zone requirements to produce an error")
+	}
+	merged := a.Intersect(b)
+	if merged.Values("zone").Len() != 0 {
+		t.Errorf("expected empty intersection, got %v", merged.Values("zone"))
+	}
+}
+
+func TestRequirementsIntersectNarrowing(t *testing.T) {
+	a := NewRequirements(selector(req("zone", v1.NodeSelectorOpIn, "us-east-1a", "us-east-1b")))
+	b := NewRequirements(selector(req("zone", v1.NodeSelectorOpIn, "us-east-1b", "us-east-1c")))
+	merged := a.Intersect(b)
+	if err := a.Compatible(b); err != nil {
+		t.Fatalf("expected compatible requirements, got %v", err)
+	}
+	if !merged.Values("zone").Has("us-east-1b") || merged.Values("zone").Len() != 1 {
+		t.Errorf("expected intersection to narrow to {us-east-1b}, got %v", merged.Values("zone"))
+	}
+}
+
+func TestRequirementsNotIn(t *testing.T) {
+	r := NewRequirements(selector(req("zone", v1.NodeSelectorOpNotIn, "us-east-1a")))
+	if r.Has("zone", "us-east-1a") {
+		t.Errorf("expected excluded value to be disallowed")
+	}
+	if !r.Has("zone", "us-east-1b") {
+		t.Errorf("expected non-excluded value to be allowed")
+	}
+}
+
+func TestRequirementsGtLt(t *testing.T) {
+	r := NewRequirements(selector(req("count", v1.NodeSelectorOpGt, "2"), req("count", v1.NodeSelectorOpLt, "5")))
+	for _, v := range []string{"3", "4"} {
+		if !r.Has("count", v) {
+			t.Errorf("expected count=%s to satisfy (2,5)", v)
+		}
+	}
+	for _, v := range []string{"2", "5", "6"} {
+		if r.Has("count", v) {
+			t.Errorf("expected count=%s to violate (2,5)", v)
+		}
+	}
+}
+
+func TestRequirementsIntersectConflictStays(t *testing.T) {
+	r := NewRequirements(selector(
+		req("count", v1.NodeSelectorOpGt, "4"),
+		req("count", v1.NodeSelectorOpLt, "3"),
+		req("count", v1.NodeSelectorOpIn, "2"),
+	))
+	if r.Has("count", "2") {
+		t.Errorf("expected count to stay permanently unsatisfiable once Gt 4 and Lt 3 conflict, got Has(\"count\", \"2\")=true")
+	}
+	if err := r.Compatible(Requirements{}); err == nil {
+		t.Errorf("expected an impossible key to be incompatible with an unconstrained Requirements")
+	}
+}
+
+func TestRequirementsGtLtMissingValue(t *testing.T) {
+	r := NewRequirements(selector(v1.NodeSelectorRequirement{Key: "count", Operator: v1.NodeSelectorOpGt}))
+	if !r.Has("count", "100") {
+		t.Errorf("expected a Gt requirement with no value to be ignored rather than constrain count")
+	}
+}
+
+func TestRequirementsKeys(t *testing.T) {
+	r := NewRequirements(selector(req("zone", v1.NodeSelectorOpExists), req("arch", v1.NodeSelectorOpIn, "amd64")))
+	keys := r.Keys()
+	if len(keys) != 2 || keys[0] != "arch" || keys[1] != "zone" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
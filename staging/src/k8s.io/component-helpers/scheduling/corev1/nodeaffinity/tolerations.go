@@ -0,0 +1,103 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeaffinity
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Tolerations is a runtime representation of []v1.Toleration, the sibling of
+// NodeSelector for the taints half of "will this pod fit on this node".
+type Tolerations struct {
+	tolerations []v1.Toleration
+}
+
+// NewTolerations returns a Tolerations for tolerations, or an error if any
+// toleration is invalid.
+func NewTolerations(tolerations []v1.Toleration, opts ...field.PathOption) (*Tolerations, error) {
+	p := field.ToPath(opts...)
+	var allErrs field.ErrorList
+	for i, toleration := range tolerations {
+		allErrs = append(allErrs, validateToleration(&toleration, p.Index(i))...)
+	}
+	if err := allErrs.ToAggregate(); err != nil {
+		return nil, err
+	}
+	return &Tolerations{tolerations: tolerations}, nil
+}
+
+func validateToleration(toleration *v1.Toleration, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if len(toleration.Key) > 0 {
+		if errs := utilvalidation.IsQualifiedName(toleration.Key); len(errs) != 0 {
+			allErrs = append(allErrs, field.Invalid(path.Child("key"), toleration.Key, fmt.Sprintf("invalid label key %q: %s", toleration.Key, strings.Join(errs, "; "))))
+		}
+	}
+	if toleration.Operator == v1.TolerationOpExists && len(toleration.Value) > 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("operator"), toleration.Operator, "value must be empty when `operator` is 'Exists'"))
+	}
+	return allErrs
+}
+
+// ToleratesTaints returns the subset of taints that none of the tolerations
+// tolerate, along with whether that subset is empty.
+func (t *Tolerations) ToleratesTaints(taints []v1.Taint) (untolerated []v1.Taint, ok bool) {
+	for _, taint := range taints {
+		if !t.tolerates(&taint) {
+			untolerated = append(untolerated, taint)
+		}
+	}
+	return untolerated, len(untolerated) == 0
+}
+
+// FilterNode returns the taints of node that none of the tolerations
+// tolerate. When effects is non-empty, only taints with one of those effects
+// are considered; otherwise every taint on the node is considered.
+func (t *Tolerations) FilterNode(node *v1.Node, effects ...v1.TaintEffect) (untolerated []v1.Taint) {
+	for _, taint := range node.Spec.Taints {
+		if len(effects) > 0 && !containsEffect(effects, taint.Effect) {
+			continue
+		}
+		if !t.tolerates(&taint) {
+			untolerated = append(untolerated, taint)
+		}
+	}
+	return untolerated
+}
+
+func (t *Tolerations) tolerates(taint *v1.Taint) bool {
+	for i := range t.tolerations {
+		if t.tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsEffect(effects []v1.TaintEffect, effect v1.TaintEffect) bool {
+	for _, e := range effects {
+		if e == effect {
+			return true
+		}
+	}
+	return false
+}
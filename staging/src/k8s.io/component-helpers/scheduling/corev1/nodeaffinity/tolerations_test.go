@@ -0,0 +1,103 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeaffinity
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewTolerationsValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		tolerations []v1.Toleration
+		wantErr     bool
+	}{
+		{
+			name:        "valid",
+			tolerations: []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "gpu", Effect: v1.TaintEffectNoSchedule}},
+		},
+		{
+			name:        "invalid key",
+			tolerations: []v1.Toleration{{Key: "invalid key", Operator: v1.TolerationOpExists}},
+			wantErr:     true,
+		},
+		{
+			name:        "exists with value",
+			tolerations: []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpExists, Value: "gpu"}},
+			wantErr:     true,
+		},
+		{
+			name:        "empty key matches all, operator exists",
+			tolerations: []v1.Toleration{{Operator: v1.TolerationOpExists}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTolerations(tt.tolerations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewTolerations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTolerationsToleratesTaints(t *testing.T) {
+	tolerations, err := NewTolerations([]v1.Toleration{
+		{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "gpu", Effect: v1.TaintEffectNoSchedule},
+	})
+	if err != nil {
+		t.Fatalf("NewTolerations() returned error: %v", err)
+	}
+	taints := []v1.Taint{
+		{Key: "dedicated", Value: "gpu", Effect: v1.TaintEffectNoSchedule},
+		{Key: "dedicated", Value: "cpu", Effect: v1.TaintEffectNoSchedule},
+	}
+	untolerated, ok := tolerations.ToleratesTaints(taints)
+	if ok {
+		t.Fatalf("expected ToleratesTaints to report untolerated taints")
+	}
+	if len(untolerated) != 1 || untolerated[0].Value != "cpu" {
+		t.Errorf("unexpected untolerated taints: %v", untolerated)
+	}
+}
+
+func TestTolerationsFilterNode(t *testing.T) {
+	tolerations, err := NewTolerations([]v1.Toleration{
+		{Key: "dedicated", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule},
+	})
+	if err != nil {
+		t.Fatalf("NewTolerations() returned error: %v", err)
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Spec: v1.NodeSpec{Taints: []v1.Taint{
+			{Key: "dedicated", Value: "gpu", Effect: v1.TaintEffectNoSchedule},
+			{Key: "dedicated", Value: "gpu", Effect: v1.TaintEffectNoExecute},
+		}},
+	}
+	untolerated := tolerations.FilterNode(node, v1.TaintEffectNoSchedule)
+	if len(untolerated) != 0 {
+		t.Errorf("expected NoSchedule taint to be tolerated, got %v", untolerated)
+	}
+	untolerated = tolerations.FilterNode(node, v1.TaintEffectNoExecute)
+	if len(untolerated) != 1 {
+		t.Errorf("expected NoExecute taint to be untolerated, got %v", untolerated)
+	}
+}